@@ -0,0 +1,42 @@
+package main
+
+import "net/http"
+
+// requireObjectAuth wraps the object routes with HTTP Basic authentication
+// against the embedded MetaStore's user bucket. It's a no-op when the
+// embedded backend isn't in use, since the external meta endpoint owns auth
+// in that case.
+func requireObjectAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Config.MetaBackend != "embedded" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, pass, ok := r.BasicAuth()
+		if !ok || !metaStore.Authenticate(user, pass) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="lfs-test-server"`)
+			w.WriteHeader(401)
+			logRequest(r, 401)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireManagementAuth protects the /mgmt subrouter with a single
+// operator password from Config, independent of any per-repo LFS users.
+func requireManagementAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pass, ok := r.BasicAuth()
+		if !ok || Config.ManagementPassword == "" || pass != Config.ManagementPassword {
+			w.Header().Set("WWW-Authenticate", `Basic realm="lfs-test-server-mgmt"`)
+			w.WriteHeader(401)
+			logRequest(r, 401)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
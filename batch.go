@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// batchObject is a single object entry in a batch request or response.
+type batchObject struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// BatchRequest is the body of a POST .../objects/batch request.
+type BatchRequest struct {
+	Operation string         `json:"operation"`
+	Transfers []string       `json:"transfers,omitempty"`
+	Objects   []*batchObject `json:"objects"`
+}
+
+// BatchResponse is the body returned from the batch endpoint. Each entry in
+// Objects carries either a set of Actions or an Error, so a failure on one
+// object does not fail the whole batch.
+type BatchResponse struct {
+	Transfer string       `json:"transfer,omitempty"`
+	Objects  []*batchMeta `json:"objects"`
+}
+
+type batchMeta struct {
+	Oid     string            `json:"oid"`
+	Size    int64             `json:"size"`
+	Actions map[string]*link  `json:"actions,omitempty"`
+	Error   *batchObjectError `json:"error,omitempty"`
+}
+
+type batchObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// basicTransfer is the only transfer adapter this server implements: plain
+// signed-URL actions, no chunked or custom transfer negotiation.
+const basicTransfer = "basic"
+
+// BatchHandler implements the Git LFS Batch API. It resolves each object in
+// the request against the meta backend Config.MetaBackend selects
+// individually, so a single missing
+// or unwriteable object doesn't fail objects that did resolve.
+func BatchHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	user := vars["user"]
+	repo := vars["repo"]
+	authz := r.Header.Get("Authorization")
+
+	var breq BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&breq); err != nil {
+		w.WriteHeader(422)
+		logRequest(r, 422)
+		return
+	}
+
+	if !selectsBasicTransfer(breq.Transfers) {
+		w.WriteHeader(422)
+		fmt.Fprint(w, `{"message":"Unsupported transfer adapter; only \"basic\" is implemented"}`)
+		logRequest(r, 422)
+		return
+	}
+
+	upload := breq.Operation == "upload"
+
+	resp := &BatchResponse{Transfer: basicTransfer, Objects: make([]*batchMeta, 0, len(breq.Objects))}
+	for _, o := range breq.Objects {
+		resp.Objects = append(resp.Objects, batchResolve(user, repo, authz, o, upload))
+	}
+
+	w.Header().Set("Content-Type", batchMediaType)
+	json.NewEncoder(w).Encode(resp)
+	logRequest(r, 200)
+}
+
+// selectsBasicTransfer reports whether the client's offered transfers (an
+// empty list means "basic only", per the Batch API spec) include the one
+// adapter this server speaks.
+func selectsBasicTransfer(offered []string) bool {
+	if len(offered) == 0 {
+		return true
+	}
+	for _, t := range offered {
+		if t == basicTransfer {
+			return true
+		}
+	}
+	return false
+}
+
+// batchResolve looks up or creates the meta record for a single batch object
+// and, on success, builds the actions the client needs to complete the
+// operation.
+func batchResolve(user, repo, authz string, o *batchObject, upload bool) *batchMeta {
+	var m *apiMeta
+	var err error
+
+	if upload {
+		m, err = metaPut(user, repo, &apiMeta{Oid: o.Oid, Size: o.Size}, authz)
+	} else {
+		m, err = metaGet(user, repo, o.Oid, authz)
+	}
+
+	if err != nil {
+		code := 404
+		var se *metaStatusError
+		if errors.As(err, &se) {
+			code = se.status
+		}
+		return &batchMeta{
+			Oid:  o.Oid,
+			Size: o.Size,
+			Error: &batchObjectError{
+				Code:    code,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	if upload && !m.Writeable {
+		return &batchMeta{
+			Oid:  m.Oid,
+			Size: m.Size,
+			Error: &batchObjectError{
+				Code:    403,
+				Message: "Forbidden",
+			},
+		}
+	}
+
+	bm := &batchMeta{Oid: m.Oid, Size: m.Size, Actions: make(map[string]*link)}
+	if upload {
+		bm.Actions["upload"] = newLink(user, repo, "PUT", m.Oid)
+		bm.Actions["verify"] = newVerifyLink(user, repo, m.Oid)
+	} else {
+		bm.Actions["download"] = newLink(user, repo, "GET", m.Oid)
+	}
+	return bm
+}
+
+// newVerifyLink points the client at this server's own verify endpoint,
+// which it hits after completing an upload so we can confirm the object
+// landed correctly.
+func newVerifyLink(user, repo, oid string) *link {
+	href := fmt.Sprintf("%s/%s/%s/objects/%s/verify", baseUrl, user, repo, oid)
+	return &link{Href: href}
+}
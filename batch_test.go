@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// withEmbeddedMeta points the package's metaStore at a fresh, temporary
+// BoltDB for the duration of the test, so batchResolve can be exercised
+// without a running Config.MetaEndpoint.
+func withEmbeddedMeta(t *testing.T) {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "lfs-test-meta-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	store, err := NewBoltMetaStore(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevBackend, prevStore := Config.MetaBackend, metaStore
+	Config.MetaBackend = "embedded"
+	metaStore = store
+
+	t.Cleanup(func() {
+		store.db.Close()
+		os.Remove(f.Name())
+		Config.MetaBackend = prevBackend
+		metaStore = prevStore
+	})
+}
+
+func TestBatchResolveUploadActions(t *testing.T) {
+	withEmbeddedMeta(t)
+
+	bm := batchResolve("alice", "repo", "", &batchObject{Oid: "abc123", Size: 10}, true)
+	if bm.Error != nil {
+		t.Fatalf("unexpected error: %+v", bm.Error)
+	}
+	if _, ok := bm.Actions["upload"]; !ok {
+		t.Error("expected an upload action")
+	}
+	if _, ok := bm.Actions["verify"]; !ok {
+		t.Error("expected a verify action")
+	}
+	if _, ok := bm.Actions["download"]; ok {
+		t.Error("an upload response should not include a download action")
+	}
+}
+
+func TestBatchResolveDownloadActions(t *testing.T) {
+	withEmbeddedMeta(t)
+
+	batchResolve("alice", "repo", "", &batchObject{Oid: "abc123", Size: 10}, true)
+
+	bm := batchResolve("alice", "repo", "", &batchObject{Oid: "abc123", Size: 10}, false)
+	if bm.Error != nil {
+		t.Fatalf("unexpected error: %+v", bm.Error)
+	}
+	if _, ok := bm.Actions["download"]; !ok {
+		t.Error("expected a download action")
+	}
+	if _, ok := bm.Actions["upload"]; ok {
+		t.Error("a download response should not include an upload action")
+	}
+}
+
+func TestBatchResolveDownloadMissingObject(t *testing.T) {
+	withEmbeddedMeta(t)
+
+	bm := batchResolve("alice", "repo", "", &batchObject{Oid: "missing", Size: 10}, false)
+	if bm.Error == nil {
+		t.Fatal("expected an error for an object that was never uploaded")
+	}
+	if bm.Error.Code != 404 {
+		t.Errorf("code = %d, want 404", bm.Error.Code)
+	}
+}
+
+func TestBatchResolveUploadNotWriteable(t *testing.T) {
+	withEmbeddedMeta(t)
+
+	batchResolve("alice", "repo", "", &batchObject{Oid: "abc123", Size: 10}, true)
+	if err := metaStore.Verify("alice", "repo", "abc123"); err != nil {
+		t.Fatal(err)
+	}
+
+	bm := batchResolve("alice", "repo", "", &batchObject{Oid: "abc123", Size: 10}, true)
+	if bm.Error == nil {
+		t.Fatal("expected an error for a verified, non-writeable object")
+	}
+	if bm.Error.Code != 403 {
+		t.Errorf("code = %d, want 403", bm.Error.Code)
+	}
+	if len(bm.Actions) != 0 {
+		t.Errorf("actions = %+v, want none", bm.Actions)
+	}
+}
+
+func TestSelectsBasicTransfer(t *testing.T) {
+	cases := []struct {
+		offered []string
+		want    bool
+	}{
+		{nil, true},
+		{[]string{"basic"}, true},
+		{[]string{"custom", "basic"}, true},
+		{[]string{"custom"}, false},
+	}
+
+	for _, c := range cases {
+		if got := selectsBasicTransfer(c.offered); got != c.want {
+			t.Errorf("selectsBasicTransfer(%v) = %v, want %v", c.offered, got, c.want)
+		}
+	}
+}
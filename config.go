@@ -0,0 +1,70 @@
+package main
+
+import "os"
+
+// Configuration holds every setting read from the environment at startup.
+// Fields are grouped by the subsystem that consumes them: the original
+// S3-backed proxy deployment (Address, MetaEndpoint), and the standalone
+// backends added since (StorageBackend/ContentPath, MetaBackend/MetaDB,
+// ManagementPassword).
+type Configuration struct {
+	// Address is the listen address, e.g. "tcp://:8080" or "fd://3".
+	Address string
+
+	// MetaEndpoint is the base URL of the external metadata service used
+	// when MetaBackend is not "embedded".
+	MetaEndpoint string
+
+	// StorageBackend selects the object Storage implementation: "s3"
+	// (default) or "filesystem".
+	StorageBackend string
+
+	// ContentPath is the root directory the filesystem Storage backend
+	// shards objects under.
+	ContentPath string
+
+	// MetaBackend selects the MetaStore implementation: "proxy" (default,
+	// talks to MetaEndpoint) or "embedded" (BoltDB, no external service).
+	MetaBackend string
+
+	// MetaDB is the path to the BoltDB file used by the embedded
+	// MetaStore.
+	MetaDB string
+
+	// ManagementPassword protects the /mgmt admin subrouter. An empty
+	// value leaves /mgmt unreachable.
+	ManagementPassword string
+}
+
+// Config is populated from the environment in init(), before main() runs,
+// so every package-level var that depends on it (storage, metaStore) must
+// be initialized lazily rather than at declaration time.
+var Config = &Configuration{
+	Address:        "tcp://:8080",
+	StorageBackend: "s3",
+	MetaBackend:    "proxy",
+}
+
+func init() {
+	if v := os.Getenv("LFS_LISTEN"); v != "" {
+		Config.Address = v
+	}
+	if v := os.Getenv("LFS_META_ENDPOINT"); v != "" {
+		Config.MetaEndpoint = v
+	}
+	if v := os.Getenv("LFS_STORAGE_BACKEND"); v != "" {
+		Config.StorageBackend = v
+	}
+	if v := os.Getenv("LFS_CONTENT_PATH"); v != "" {
+		Config.ContentPath = v
+	}
+	if v := os.Getenv("LFS_META_BACKEND"); v != "" {
+		Config.MetaBackend = v
+	}
+	if v := os.Getenv("LFS_META_DB"); v != "" {
+		Config.MetaDB = v
+	}
+	if v := os.Getenv("LFS_MANAGEMENT_PASSWORD"); v != "" {
+		Config.ManagementPassword = v
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -14,11 +14,13 @@ import (
 	"path/filepath"
 	"strconv"
 	"syscall"
+	"time"
 )
 
 const (
 	contentMediaType = "application/vnd.git-media"
 	metaMediaType    = contentMediaType + "+json"
+	batchMediaType   = "application/vnd.git-lfs+json"
 )
 
 var (
@@ -40,8 +42,9 @@ type apiMeta struct {
 }
 
 type link struct {
-	Href   string            `json:"href"`
-	Header map[string]string `json:"header,omitempty"`
+	Href      string            `json:"href"`
+	Header    map[string]string `json:"header,omitempty"`
+	ExpiresAt string            `json:"expires_at,omitempty"`
 }
 
 func main() {
@@ -102,13 +105,39 @@ func newServer() http.Handler {
 	router := mux.NewRouter()
 
 	o := router.PathPrefix("/{user}/{repo}/objects").Subrouter()
+	o.Use(requireObjectAuth)
 	o.Methods("POST").Headers("Accept", metaMediaType).HandlerFunc(PostHandler)
+	o.Methods("POST").Path("/batch").Headers("Accept", batchMediaType).HandlerFunc(BatchHandler)
 
 	s := o.Path("/{oid}").Subrouter()
 	s.Methods("GET", "HEAD").Headers("Accept", contentMediaType).HandlerFunc(GetContentHandler)
 	s.Methods("GET", "HEAD").Headers("Accept", metaMediaType).HandlerFunc(GetMetaHandler)
 	s.Methods("OPTIONS").Headers("Accept", contentMediaType).HandlerFunc(OptionsHandler)
-	s.Methods("PUT").Headers("Accept", contentMediaType).HandlerFunc(PutHandler)
+	s.Methods("PUT").Headers("Accept", contentMediaType).HandlerFunc(PutContentHandler)
+
+	// A server-owned link handed out by newLink (filesystem backend, or any
+	// backend SignedURL can't presign for) is just a bare href with no
+	// Accept requirement of its own — basic-transfer clients GET/PUT it
+	// without resending the content Accept header, so it needs routes that
+	// don't gate on one.
+	s.Methods("GET", "HEAD").HandlerFunc(GetContentHandler)
+	s.Methods("PUT").HandlerFunc(PutContentHandler)
+
+	// Routes below /{oid} hang off o, not s: s's path matcher is anchored to
+	// exactly /{oid}, so nothing longer ever reaches its routes.
+	o.Methods("POST").Path("/{oid}/verify").HandlerFunc(VerifyHandler)
+	o.Methods("POST").Path("/{oid}/uploads").HandlerFunc(StartUploadHandler)
+
+	u := o.Path("/{oid}/uploads/{uuid}").Subrouter()
+	u.Methods("PATCH").HandlerFunc(PatchUploadHandler)
+	u.Methods("PUT").HandlerFunc(FinishUploadHandler)
+
+	mgmt := router.PathPrefix("/mgmt").Subrouter()
+	mgmt.Use(requireManagementAuth)
+	mgmt.Methods("GET").Path("/users").HandlerFunc(ListUsersHandler)
+	mgmt.Methods("POST").Path("/users").HandlerFunc(CreateUserHandler)
+	mgmt.Methods("DELETE").Path("/users/{name}").HandlerFunc(DeleteUserHandler)
+	mgmt.Methods("GET").Path("/objects").HandlerFunc(ListObjectsHandler)
 
 	return router
 }
@@ -121,10 +150,77 @@ func GetContentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := S3SignQuery("GET", oidPath(meta.Oid), 86400)
-	w.Header().Set("Location", token.Location)
-	w.WriteHeader(302)
-	logRequest(r, 302)
+	href, header, err := storage.SignedURL("GET", meta.Oid, linkExpiry)
+	if err == nil {
+		for k, v := range header {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("Location", href)
+		w.WriteHeader(302)
+		logRequest(r, 302)
+		return
+	}
+
+	serveContent(w, r, meta.Oid)
+}
+
+// serveContent streams an object straight from a backend that can't hand
+// out its own presigned URLs (e.g. filesystem). http.ServeContent takes
+// care of Content-Length, Accept-Ranges, and Range requests.
+func serveContent(w http.ResponseWriter, r *http.Request, oid string) {
+	f, err := storage.Get(oid)
+	if err != nil {
+		w.WriteHeader(404)
+		logRequest(r, 404)
+		return
+	}
+	defer f.Close()
+
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		logger.Printf("[CONTENT] error - backend file does not support seeking")
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	http.ServeContent(w, r, oid, time.Time{}, rs)
+	logRequest(r, 200)
+}
+
+// PutContentHandler accepts raw object content directly, for storage
+// backends (like filesystem) where upload links point back at the server
+// itself instead of a presigned URL.
+func PutContentHandler(w http.ResponseWriter, r *http.Request) {
+	if !storageAcceptsContent() {
+		w.WriteHeader(501)
+		fmt.Fprint(w, `{"message":"Direct uploads require a storage backend that accepts direct writes"}`)
+		logRequest(r, 501)
+		return
+	}
+
+	vars := mux.Vars(r)
+	user, repo, oid := vars["user"], vars["repo"], vars["oid"]
+
+	// r.ContentLength is -1 for chunked requests, so size the object from
+	// what storage.Put actually wrote rather than trusting the header.
+	cr := &countingReader{r: r.Body}
+	if err := storage.Put(oid, cr); err != nil {
+		logger.Printf("[CONTENT] error - %s", err)
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	if _, err := metaRecord(user, repo, oid, cr.n, r.Header.Get("Authorization")); err != nil {
+		logger.Printf("[CONTENT] error - %s", err)
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	w.WriteHeader(200)
+	logRequest(r, 200)
 }
 
 func GetMetaHandler(w http.ResponseWriter, r *http.Request) {
@@ -138,7 +234,8 @@ func GetMetaHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", metaMediaType)
 
-	meta := newMeta(m, false)
+	vars := mux.Vars(r)
+	meta := newMeta(vars["user"], vars["repo"], m, false)
 	enc := json.NewEncoder(w)
 	enc.Encode(meta)
 	logRequest(r, 200)
@@ -164,7 +261,8 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(201)
 	}
 
-	meta := newMeta(m, true)
+	vars := mux.Vars(r)
+	meta := newMeta(vars["user"], vars["repo"], m, true)
 	enc := json.NewEncoder(w)
 	enc.Encode(meta)
 	logRequest(r, 201)
@@ -192,21 +290,22 @@ func OptionsHandler(w http.ResponseWriter, r *http.Request) {
 	logRequest(r, 200)
 }
 
-func PutHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(405)
-	logRequest(r, 405)
-}
-
 func getMeta(r *http.Request) (*apiMeta, error) {
 	vars := mux.Vars(r)
 	user := vars["user"]
 	repo := vars["repo"]
 	oid := vars["oid"]
 
-	authz := r.Header.Get("Authorization")
+	return metaGet(user, repo, oid, r.Header.Get("Authorization"))
+}
+
+// requestMeta proxies a single meta lookup/creation to Config.MetaEndpoint.
+// It backs both the legacy per-object handlers and the batch API, which
+// calls it once per object in the batch.
+func requestMeta(method, user, repo, oid, authz string, body io.Reader) (*apiMeta, error) {
 	url := Config.MetaEndpoint + "/" + filepath.Join(user, repo, oid)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		logger.Printf("[META] error - %s", err)
 		return nil, err
@@ -222,7 +321,7 @@ func getMeta(r *http.Request) (*apiMeta, error) {
 	}
 
 	defer res.Body.Close()
-	if res.StatusCode == 200 {
+	if res.StatusCode == 200 || res.StatusCode == 201 {
 		var m apiMeta
 		dec := json.NewDecoder(res.Body)
 		err := dec.Decode(&m)
@@ -231,11 +330,24 @@ func getMeta(r *http.Request) (*apiMeta, error) {
 			return nil, err
 		}
 
+		m.existing = res.StatusCode == 200
+
 		return &m, nil
 	}
 
 	logger.Printf("[META] status - %d", res.StatusCode)
-	return nil, fmt.Errorf("status: %d", res.StatusCode)
+	return nil, &metaStatusError{status: res.StatusCode}
+}
+
+// metaStatusError preserves the HTTP status Config.MetaEndpoint responded
+// with, so callers further up (like the batch API) can report the real
+// failure reason instead of guessing.
+type metaStatusError struct {
+	status int
+}
+
+func (e *metaStatusError) Error() string {
+	return fmt.Sprintf("status: %d", e.status)
 }
 
 func sendMeta(r *http.Request) (*apiMeta, error) {
@@ -251,69 +363,41 @@ func sendMeta(r *http.Request) (*apiMeta, error) {
 		return nil, err
 	}
 
-	authz := r.Header.Get("Authorization")
-	url := Config.MetaEndpoint + "/" + filepath.Join(user, repo, m.Oid)
-
-	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	enc.Encode(&m)
-
-	req, err := http.NewRequest("POST", url, &buf)
-	if err != nil {
-		logger.Printf("[META] error - %s", err)
-		return nil, err
-	}
-	if authz != "" {
-		req.Header.Set("Authorization", authz)
-	}
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		logger.Printf("[META] error - %s", err)
-		return nil, err
-	}
-
-	defer res.Body.Close()
-	if res.StatusCode == 200 || res.StatusCode == 201 {
-		var m apiMeta
-		dec := json.NewDecoder(res.Body)
-		err := dec.Decode(&m)
-		if err != nil {
-			logger.Printf("[META] error - %s", err)
-			return nil, err
-		}
-
-		m.existing = res.StatusCode == 200
-
-		return &m, nil
-	}
-
-	logger.Printf("[META] status - %d", res.StatusCode)
-	return nil, fmt.Errorf("status: %d", res.StatusCode)
+	return metaPut(user, repo, &m, r.Header.Get("Authorization"))
 }
 
-func newMeta(m *apiMeta, upload bool) *Meta {
+func newMeta(user, repo string, m *apiMeta, upload bool) *Meta {
 	meta := &Meta{
 		Oid:   m.Oid,
 		Size:  m.Size,
 		Links: make(map[string]*link),
 	}
-	meta.Links["download"] = newLink("GET", meta.Oid)
+	meta.Links["download"] = newLink(user, repo, "GET", meta.Oid)
 	if upload {
-		meta.Links["upload"] = newLink("PUT", meta.Oid)
-		meta.Links["callback"] = &link{Href: "http://example.com/callmemaybe"}
+		meta.Links["upload"] = newLink(user, repo, "PUT", meta.Oid)
+		meta.Links["verify"] = newVerifyLink(user, repo, meta.Oid)
 	}
 	return meta
 }
 
-func newLink(method, oid string) *link {
-	token := S3SignHeader(method, oidPath(oid), oid)
-	header := make(map[string]string)
-	header["Authorization"] = token.Token
-	header["x-amz-content-sha256"] = oid
-	header["x-amz-date"] = token.Time.Format(isoLayout)
+// linkExpiry is how long a presigned link returned from newLink stays valid.
+const linkExpiry = 86400 * time.Second
+
+// newLink asks the active Storage backend to sign a URL for the given
+// object. Backends that can't hand out a signed URL of their own (e.g.
+// filesystem) get a link pointing back at this server's own object
+// endpoint instead.
+func newLink(user, repo, method, oid string) *link {
+	href, header, err := storage.SignedURL(method, oid, linkExpiry)
+	if err != nil {
+		href = fmt.Sprintf("%s/%s/%s/objects/%s", baseUrl, user, repo, oid)
+	}
 
-	return &link{Href: token.Location, Header: header}
+	return &link{
+		Href:      href,
+		Header:    header,
+		ExpiresAt: time.Now().Add(linkExpiry).Format(time.RFC3339),
+	}
 }
 
 func oidPath(oid string) string {
@@ -322,6 +406,20 @@ func oidPath(oid string) string {
 	return filepath.Join("/", dir, oid)
 }
 
+// countingReader tallies bytes as they're read, so a handler can learn how
+// much its reader actually yielded once a consumer like storage.Put is done
+// with it, rather than trusting a request's (possibly absent) Content-Length.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func logRequest(r *http.Request, status int) {
 	logger.Printf("[%s] %s - %d", r.Method, r.URL, status)
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// userRequest is the body of a POST /mgmt/users request.
+type userRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// ListUsersHandler lists the usernames provisioned in the embedded store.
+func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if metaStore == nil {
+		w.WriteHeader(501)
+		logRequest(r, 501)
+		return
+	}
+
+	users, err := metaStore.Users()
+	if err != nil {
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(users)
+	logRequest(r, 200)
+}
+
+// CreateUserHandler provisions a new LFS user against the embedded store.
+func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	if metaStore == nil {
+		w.WriteHeader(501)
+		logRequest(r, 501)
+		return
+	}
+
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		w.WriteHeader(422)
+		logRequest(r, 422)
+		return
+	}
+
+	if err := metaStore.AddUser(req.Name, req.Password); err != nil {
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	w.WriteHeader(201)
+	logRequest(r, 201)
+}
+
+// DeleteUserHandler removes an LFS user from the embedded store.
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	if metaStore == nil {
+		w.WriteHeader(501)
+		logRequest(r, 501)
+		return
+	}
+
+	if err := metaStore.DeleteUser(mux.Vars(r)["name"]); err != nil {
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	w.WriteHeader(200)
+	logRequest(r, 200)
+}
+
+// ListObjectsHandler lists every object the embedded store knows about, for
+// operators inspecting what a test run actually pushed.
+func ListObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	if metaStore == nil {
+		w.WriteHeader(501)
+		logRequest(r, 501)
+		return
+	}
+
+	objects, err := metaStore.Objects()
+	if err != nil {
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(objects)
+	logRequest(r, 200)
+}
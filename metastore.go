@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// storedObject is the embedded store's record for one object, keyed by
+// user/repo/oid. It carries a little more state than the wire-level
+// apiMeta (verified, createdAt) since it's the source of truth rather than
+// a request/response shape.
+type storedObject struct {
+	User      string    `json:"user"`
+	Repo      string    `json:"repo"`
+	Oid       string    `json:"oid"`
+	Size      int64     `json:"size"`
+	Writeable bool      `json:"writeable"`
+	Verified  bool      `json:"verified"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MetaStore abstracts where object records and users live, so the server
+// can run against Config.MetaEndpoint (the original HTTP proxy) or this
+// embedded store with no other code caring which.
+type MetaStore interface {
+	Get(user, repo, oid string) (*storedObject, error)
+	Put(user, repo, oid string, size int64) (*storedObject, error)
+	Verify(user, repo, oid string) error
+	Objects() ([]*storedObject, error)
+	AddUser(user, pass string) error
+	DeleteUser(user string) error
+	Users() ([]string, error)
+	Authenticate(user, pass string) bool
+}
+
+const (
+	objectsBucket = "objects"
+	usersBucket   = "users"
+)
+
+// boltMetaStore is the embedded MetaStore, so lfs-test-server can run with
+// no external services at all.
+type boltMetaStore struct {
+	db *bolt.DB
+}
+
+// NewBoltMetaStore opens (and initializes, if new) a BoltDB file at path.
+func NewBoltMetaStore(path string) (*boltMetaStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(objectsBucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte(usersBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltMetaStore{db: db}, nil
+}
+
+func objectKey(user, repo, oid string) []byte {
+	return []byte(strings.Join([]string{user, repo, oid}, "/"))
+}
+
+func (s *boltMetaStore) Get(user, repo, oid string) (*storedObject, error) {
+	var obj storedObject
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(objectsBucket)).Get(objectKey(user, repo, oid))
+		if v == nil {
+			return errors.New("object not found")
+		}
+		return json.Unmarshal(v, &obj)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// Put creates or updates an object record. An object that's already been
+// verified is no longer writeable - clients shouldn't be able to silently
+// replace a blob another client already confirmed landed in storage.
+func (s *boltMetaStore) Put(user, repo, oid string, size int64) (*storedObject, error) {
+	var obj storedObject
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(objectsBucket))
+		key := objectKey(user, repo, oid)
+
+		if existing := b.Get(key); existing != nil {
+			if err := json.Unmarshal(existing, &obj); err != nil {
+				return err
+			}
+			obj.Size = size
+			obj.Writeable = !obj.Verified
+		} else {
+			obj = storedObject{
+				User:      user,
+				Repo:      repo,
+				Oid:       oid,
+				Size:      size,
+				Writeable: true,
+				CreatedAt: time.Now(),
+			}
+		}
+
+		v, err := json.Marshal(&obj)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (s *boltMetaStore) Verify(user, repo, oid string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(objectsBucket))
+		key := objectKey(user, repo, oid)
+
+		v := b.Get(key)
+		if v == nil {
+			return errors.New("object not found")
+		}
+
+		var obj storedObject
+		if err := json.Unmarshal(v, &obj); err != nil {
+			return err
+		}
+		obj.Verified = true
+		obj.Writeable = false
+
+		nv, err := json.Marshal(&obj)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, nv)
+	})
+}
+
+func (s *boltMetaStore) Objects() ([]*storedObject, error) {
+	var objs []*storedObject
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(objectsBucket)).ForEach(func(k, v []byte) error {
+			var obj storedObject
+			if err := json.Unmarshal(v, &obj); err != nil {
+				return err
+			}
+			objs = append(objs, &obj)
+			return nil
+		})
+	})
+	return objs, err
+}
+
+func (s *boltMetaStore) AddUser(user, pass string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(usersBucket)).Put([]byte(user), []byte(hashPassword(pass)))
+	})
+}
+
+func (s *boltMetaStore) DeleteUser(user string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(usersBucket)).Delete([]byte(user))
+	})
+}
+
+func (s *boltMetaStore) Users() ([]string, error) {
+	var users []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(usersBucket)).ForEach(func(k, v []byte) error {
+			users = append(users, string(k))
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (s *boltMetaStore) Authenticate(user, pass string) bool {
+	var hash []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		hash = tx.Bucket([]byte(usersBucket)).Get([]byte(user))
+		return nil
+	})
+	return hash != nil && string(hash) == hashPassword(pass)
+}
+
+func hashPassword(pass string) string {
+	sum := sha256.Sum256([]byte(pass))
+	return hex.EncodeToString(sum[:])
+}
+
+// metaStore is non-nil only when Config.MetaBackend == "embedded".
+var metaStore MetaStore
+
+func init() {
+	if Config.MetaBackend != "embedded" {
+		return
+	}
+
+	store, err := NewBoltMetaStore(Config.MetaDB)
+	if err != nil {
+		logger.Fatalf("Could not open meta store at %s: %s", Config.MetaDB, err)
+	}
+	metaStore = store
+}
+
+// metaGet resolves a single object against whichever backend
+// Config.MetaBackend selects. It backs getMeta and the batch API's download
+// path, so both go through the same lookup regardless of backend.
+func metaGet(user, repo, oid, authz string) (*apiMeta, error) {
+	if Config.MetaBackend == "embedded" {
+		obj, err := metaStore.Get(user, repo, oid)
+		if err != nil {
+			return nil, err
+		}
+		return &apiMeta{Oid: obj.Oid, Size: obj.Size, Writeable: obj.Writeable}, nil
+	}
+
+	return requestMeta("GET", user, repo, oid, authz, nil)
+}
+
+// metaPut creates or updates an object record against whichever backend
+// Config.MetaBackend selects. It backs sendMeta and the batch API's upload
+// path.
+func metaPut(user, repo string, m *apiMeta, authz string) (*apiMeta, error) {
+	if Config.MetaBackend == "embedded" {
+		_, getErr := metaStore.Get(user, repo, m.Oid)
+		obj, err := metaStore.Put(user, repo, m.Oid, m.Size)
+		if err != nil {
+			return nil, err
+		}
+		return &apiMeta{Oid: obj.Oid, Size: obj.Size, Writeable: obj.Writeable, existing: getErr == nil}, nil
+	}
+
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(m)
+	return requestMeta("POST", user, repo, m.Oid, authz, &buf)
+}
+
+// metaRecord registers an object with whichever backend Config.MetaBackend
+// selects. It backs the upload paths that don't go through sendMeta's JSON
+// POST body directly (resumable uploads, direct content PUT).
+func metaRecord(user, repo, oid string, size int64, authz string) (*apiMeta, error) {
+	if Config.MetaBackend == "embedded" {
+		obj, err := metaStore.Put(user, repo, oid, size)
+		if err != nil {
+			return nil, err
+		}
+		return &apiMeta{Oid: obj.Oid, Size: obj.Size, Writeable: obj.Writeable}, nil
+	}
+
+	body := fmt.Sprintf(`{"oid":"%s","size":%d}`, oid, size)
+	return requestMeta("POST", user, repo, oid, authz, strings.NewReader(body))
+}
+
+// metaVerify marks an object verified with whichever backend
+// Config.MetaBackend selects.
+func metaVerify(user, repo, oid, authz string) error {
+	if Config.MetaBackend == "embedded" {
+		return metaStore.Verify(user, repo, oid)
+	}
+
+	_, err := requestMeta("PUT", user, repo, oid+"/verify", authz, nil)
+	return err
+}
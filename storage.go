@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNoSignedURL is returned by backends that can't hand a client a
+// presigned URL of their own (e.g. filesystem) so callers know to fall
+// back to serving or accepting content through the server itself.
+var ErrNoSignedURL = errors.New("storage: backend does not support signed URLs")
+
+// Storage abstracts the object backend so the server can run against S3
+// (the original behavior) or a local filesystem, with no other code caring
+// which one is in play.
+type Storage interface {
+	Get(oid string) (io.ReadCloser, error)
+	Put(oid string, r io.Reader) error
+	Exists(oid string) (int64, bool)
+	SignedURL(method, oid string, ttl time.Duration) (href string, header map[string]string, err error)
+
+	// AcceptsContent reports whether Put actually accepts bytes, as opposed
+	// to only being reachable through a presigned URL. Callers that need to
+	// write content through the server itself (direct PUT, resumable
+	// uploads) check this before starting, rather than failing partway
+	// through.
+	AcceptsContent() bool
+}
+
+// storageAcceptsContent reports whether the active backend can take
+// content written through the server itself.
+func storageAcceptsContent() bool {
+	return storage.AcceptsContent()
+}
+
+// newStorage picks the backend named by Config.StorageBackend.
+func newStorage() Storage {
+	switch Config.StorageBackend {
+	case "filesystem":
+		return &filesystemStorage{root: Config.ContentPath}
+	default:
+		return &s3Storage{}
+	}
+}
+
+// storage is non-nil only once init() below has run, after Config is fully
+// populated. Declaring it via `= newStorage()` here would read Config
+// before its env overrides are applied, the same hazard metaStore avoids
+// by assigning inside init().
+var storage Storage
+
+func init() {
+	storage = newStorage()
+}
+
+// filesystemStorage shards objects on disk the same way oidPath does, so a
+// blob's path is predictable regardless of which backend is in use.
+type filesystemStorage struct {
+	root string
+}
+
+func (s *filesystemStorage) Get(oid string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.root, oidPath(oid)))
+}
+
+func (s *filesystemStorage) Put(oid string, r io.Reader) error {
+	path := filepath.Join(s.root, oidPath(oid))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (s *filesystemStorage) Exists(oid string) (int64, bool) {
+	fi, err := os.Stat(filepath.Join(s.root, oidPath(oid)))
+	if err != nil {
+		return 0, false
+	}
+	return fi.Size(), true
+}
+
+func (s *filesystemStorage) SignedURL(method, oid string, ttl time.Duration) (string, map[string]string, error) {
+	return "", nil, ErrNoSignedURL
+}
+
+func (s *filesystemStorage) AcceptsContent() bool {
+	return true
+}
+
+// s3Storage is the original backend: objects live in S3 and clients talk to
+// it directly via presigned URLs, never through this server's own bytes.
+type s3Storage struct{}
+
+func (s *s3Storage) Get(oid string) (io.ReadCloser, error) {
+	return nil, errors.New("storage: s3 backend does not serve content directly, use SignedURL")
+}
+
+func (s *s3Storage) Put(oid string, r io.Reader) error {
+	return errors.New("storage: s3 backend does not accept content directly, use SignedURL")
+}
+
+func (s *s3Storage) Exists(oid string) (int64, bool) {
+	return S3Stat(oidPath(oid))
+}
+
+// SignedURL signs a query-string URL for GET, so the client can be handed a
+// plain redirect, and a header-signed URL for PUT, since an upload needs
+// the signature carried alongside the request rather than embedded in it.
+func (s *s3Storage) SignedURL(method, oid string, ttl time.Duration) (string, map[string]string, error) {
+	if method == "GET" {
+		token := S3SignQuery(method, oidPath(oid), int64(ttl.Seconds()))
+		return token.Location, nil, nil
+	}
+
+	token := S3SignHeader(method, oidPath(oid), oid)
+	header := map[string]string{
+		"Authorization":        token.Token,
+		"x-amz-content-sha256": oid,
+		"x-amz-date":           token.Time.Format(isoLayout),
+	}
+	return token.Location, header, nil
+}
+
+func (s *s3Storage) AcceptsContent() bool {
+	return false
+}
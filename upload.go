@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// uploadSession tracks one in-progress resumable (PATCH+Range) upload, for
+// clients that can't use a presigned S3 PUT directly. Modeled on Docker
+// distribution's blob writer: bytes are appended to a temp file as they
+// arrive, and the SHA-256 is computed incrementally so finalization is just
+// a digest comparison.
+type uploadSession struct {
+	user, repo, oid string
+	file            *os.File
+	path            string
+	offset          int64
+	hash            hash.Hash
+	startedAt       time.Time
+
+	// mu guards file, offset, and hash, which PatchUploadHandler and
+	// FinishUploadHandler (and the stale-session reaper) all mutate.
+	// uploadsMu only protects the uploads map itself, not what a session
+	// points at, so two PATCHes racing on the same uuid need their own lock.
+	mu sync.Mutex
+}
+
+const uploadSessionTTL = time.Hour
+
+var (
+	uploadsMu sync.Mutex
+	uploads   = make(map[string]*uploadSession)
+)
+
+func init() {
+	go reapStaleUploads()
+}
+
+// StartUploadHandler begins a resumable upload session and hands the client
+// back a Location to PATCH bytes to.
+func StartUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !storageAcceptsContent() {
+		w.WriteHeader(501)
+		fmt.Fprint(w, `{"message":"Resumable uploads require a storage backend that accepts direct writes"}`)
+		logRequest(r, 501)
+		return
+	}
+
+	vars := mux.Vars(r)
+	user, repo, oid := vars["user"], vars["repo"], vars["oid"]
+
+	tmp, err := ioutil.TempFile("", "lfs-upload-")
+	if err != nil {
+		logger.Printf("[UPLOAD] error - %s", err)
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	id := newUploadID()
+	sess := &uploadSession{
+		user:      user,
+		repo:      repo,
+		oid:       oid,
+		file:      tmp,
+		path:      tmp.Name(),
+		hash:      sha256.New(),
+		startedAt: time.Now(),
+	}
+
+	uploadsMu.Lock()
+	uploads[id] = sess
+	uploadsMu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%s/%s/objects/%s/uploads/%s", baseUrl, user, repo, oid, id))
+	w.Header().Set("Range", "0-0")
+	w.WriteHeader(202)
+	logRequest(r, 202)
+}
+
+// PatchUploadHandler appends a chunk to an upload session, rejecting any
+// chunk that doesn't pick up exactly where the server left off.
+func PatchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := lookupUpload(w, r)
+	if !ok {
+		return
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	start, ok := parseContentRangeStart(r.Header.Get("Content-Range"))
+	if !ok || start != sess.offset {
+		w.WriteHeader(416)
+		logRequest(r, 416)
+		return
+	}
+
+	n, err := io.Copy(io.MultiWriter(sess.file, sess.hash), r.Body)
+	if err != nil {
+		logger.Printf("[UPLOAD] error - %s", err)
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+	sess.offset += n
+
+	w.Header().Set("Range", fmt.Sprintf("0-%d", sess.offset))
+	w.WriteHeader(202)
+	logRequest(r, 202)
+}
+
+// FinishUploadHandler verifies the streamed digest against the oid, moves
+// the temp file into permanent storage, and records it with the meta store.
+func FinishUploadHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := lookupUpload(w, r)
+	if !ok {
+		return
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	digest := strings.TrimPrefix(r.Header.Get("Digest"), "sha256=")
+	sum := hex.EncodeToString(sess.hash.Sum(nil))
+	if digest != "" && digest != sum {
+		w.WriteHeader(400)
+		fmt.Fprint(w, `{"message":"Digest header does not match streamed content"}`)
+		logRequest(r, 400)
+		return
+	}
+	if sum != sess.oid {
+		w.WriteHeader(400)
+		fmt.Fprint(w, `{"message":"Content does not match the requested oid"}`)
+		logRequest(r, 400)
+		return
+	}
+
+	sess.file.Close()
+	f, err := os.Open(sess.path)
+	if err != nil {
+		logger.Printf("[UPLOAD] error - %s", err)
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+	defer f.Close()
+	defer os.Remove(sess.path)
+
+	if err := storage.Put(sess.oid, f); err != nil {
+		logger.Printf("[UPLOAD] error - %s", err)
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	removeUpload(mux.Vars(r)["uuid"])
+
+	if _, err := metaRecord(sess.user, sess.repo, sess.oid, sess.offset, r.Header.Get("Authorization")); err != nil {
+		logger.Printf("[UPLOAD] error - %s", err)
+		w.WriteHeader(500)
+		logRequest(r, 500)
+		return
+	}
+
+	w.WriteHeader(200)
+	logRequest(r, 200)
+}
+
+func lookupUpload(w http.ResponseWriter, r *http.Request) (*uploadSession, bool) {
+	id := mux.Vars(r)["uuid"]
+
+	uploadsMu.Lock()
+	sess, ok := uploads[id]
+	uploadsMu.Unlock()
+
+	if !ok {
+		w.WriteHeader(404)
+		logRequest(r, 404)
+		return nil, false
+	}
+	return sess, true
+}
+
+func removeUpload(id string) {
+	uploadsMu.Lock()
+	delete(uploads, id)
+	uploadsMu.Unlock()
+}
+
+func reapStaleUploads() {
+	ticker := time.NewTicker(10 * time.Minute)
+	for range ticker.C {
+		cutoff := time.Now().Add(-uploadSessionTTL)
+
+		uploadsMu.Lock()
+		for id, sess := range uploads {
+			if sess.startedAt.Before(cutoff) {
+				sess.mu.Lock()
+				sess.file.Close()
+				os.Remove(sess.path)
+				sess.mu.Unlock()
+				delete(uploads, id)
+			}
+		}
+		uploadsMu.Unlock()
+	}
+}
+
+// parseContentRangeStart parses the start offset out of a "bytes start-end"
+// or "bytes start-end/total" Content-Range header.
+func parseContentRangeStart(cr string) (int64, bool) {
+	cr = strings.TrimPrefix(cr, "bytes ")
+	dash := strings.Index(cr, "-")
+	if dash < 0 {
+		return 0, false
+	}
+
+	start, err := strconv.ParseInt(cr[:dash], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return start, true
+}
+
+func newUploadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
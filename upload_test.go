@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestParseContentRangeStart(t *testing.T) {
+	cases := []struct {
+		header string
+		want   int64
+		ok     bool
+	}{
+		{"bytes 0-99", 0, true},
+		{"bytes 100-199", 100, true},
+		{"bytes 100-199/200", 100, true},
+		{"bogus", 0, false},
+		{"", 0, false},
+	}
+
+	for _, c := range cases {
+		got, ok := parseContentRangeStart(c.header)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("parseContentRangeStart(%q) = (%d, %v), want (%d, %v)", c.header, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// VerifyHandler confirms that an object a client just pushed to storage
+// actually landed there with the size the client claimed. Clients hit this
+// after completing an upload, using the "verify" link newMeta hands back.
+func VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	user := vars["user"]
+	repo := vars["repo"]
+	oid := vars["oid"]
+
+	m, err := metaGet(user, repo, oid, r.Header.Get("Authorization"))
+	if err != nil {
+		w.WriteHeader(404)
+		logRequest(r, 404)
+		return
+	}
+
+	size, ok := storage.Exists(oid)
+	if !ok || size != m.Size {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, `{"message":"Object size mismatch or object missing"}`)
+		logRequest(r, 422)
+		return
+	}
+
+	if err := metaVerify(user, repo, oid, r.Header.Get("Authorization")); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, `{"message":"%s"}`, err)
+		logRequest(r, 422)
+		return
+	}
+
+	w.WriteHeader(200)
+	logRequest(r, 200)
+}
+
+// S3Stat HEADs an object in S3 via a presigned query-string URL and reports
+// its size, without needing any credentials of its own.
+func S3Stat(path string) (int64, bool) {
+	token := S3SignQuery("HEAD", path, 86400)
+
+	req, err := http.NewRequest("HEAD", token.Location, nil)
+	if err != nil {
+		logger.Printf("[S3] error - %s", err)
+		return 0, false
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Printf("[S3] error - %s", err)
+		return 0, false
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return 0, false
+	}
+
+	return res.ContentLength, true
+}